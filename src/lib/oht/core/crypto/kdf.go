@@ -0,0 +1,160 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF abstracts the key-derivation function used to stretch a passphrase
+// into key material. Implementations are registered in kdfRegistry and
+// selected by the "kdf" field persisted in the encrypted key JSON, so a
+// keystore written with one KDF can still be opened once another becomes
+// the default.
+type KDF interface {
+	// Name is the value stored in the "kdf" JSON field.
+	Name() string
+	// DefaultParams returns the params a fresh keystore should persist
+	// under "kdfparams" (excluding "salt" and "dklen", which callers fill
+	// in per key).
+	DefaultParams() map[string]interface{}
+	// Derive stretches password/salt into dkLen bytes of key material
+	// according to params.
+	Derive(password, salt []byte, params map[string]interface{}, dkLen int) ([]byte, error)
+}
+
+var kdfRegistry = map[string]KDF{
+	"scrypt":   scryptKDF{},
+	"pbkdf2":   pbkdf2KDF{},
+	"argon2id": argon2idKDF{},
+}
+
+// resolveKDF picks the KDF and its param set for a given safety level
+// (KDFStandard, KDFLight or KDFArgon2), then applies any caller-supplied
+// overrides on top. Shared by every KeyStore constructor so file-backed and
+// bolt-backed keystores agree on what "standard"/"light"/"argon2" mean.
+func resolveKDF(safety int, kdfParams ...map[string]interface{}) (KDF, map[string]interface{}) {
+	var kdf KDF
+	var params map[string]interface{}
+
+	switch safety {
+	case KDFArgon2:
+		kdf = kdfRegistry["argon2id"]
+		params = kdf.DefaultParams()
+	case KDFLight:
+		kdf = kdfRegistry["scrypt"]
+		params = map[string]interface{}{"n": lightScryptN, "r": scryptR, "p": lightScryptP}
+	default:
+		kdf = kdfRegistry["scrypt"]
+		params = map[string]interface{}{"n": stdScryptN, "r": scryptR, "p": stdScryptP}
+	}
+
+	if len(kdfParams) > 0 {
+		for k, v := range kdfParams[0] {
+			params[k] = v
+		}
+	}
+	return kdf, params
+}
+
+type scryptKDF struct{}
+
+func (scryptKDF) Name() string { return "scrypt" }
+
+func (scryptKDF) DefaultParams() map[string]interface{} {
+	return map[string]interface{}{
+		"n": stdScryptN,
+		"r": scryptR,
+		"p": stdScryptP,
+	}
+}
+
+func (scryptKDF) Derive(password, salt []byte, params map[string]interface{}, dkLen int) ([]byte, error) {
+	n, ok := safeEnsureInt(params["n"])
+	if !ok {
+		return nil, errors.New(`scrypt: missing or invalid "n" param`)
+	}
+	r, ok := safeEnsureInt(params["r"])
+	if !ok {
+		return nil, errors.New(`scrypt: missing or invalid "r" param`)
+	}
+	p, ok := safeEnsureInt(params["p"])
+	if !ok {
+		return nil, errors.New(`scrypt: missing or invalid "p" param`)
+	}
+	return scrypt.Key(password, salt, n, r, p, dkLen)
+}
+
+type pbkdf2KDF struct{}
+
+func (pbkdf2KDF) Name() string { return "pbkdf2" }
+
+func (pbkdf2KDF) DefaultParams() map[string]interface{} {
+	return map[string]interface{}{
+		"c":   262144,
+		"prf": "hmac-sha256",
+	}
+}
+
+func (pbkdf2KDF) Derive(password, salt []byte, params map[string]interface{}, dkLen int) ([]byte, error) {
+	c, ok := safeEnsureInt(params["c"])
+	if !ok {
+		return nil, errors.New(`pbkdf2: missing or invalid "c" param`)
+	}
+	prf, _ := params["prf"].(string)
+	if prf != "hmac-sha256" {
+		return nil, fmt.Errorf("Unsupported PBKDF2 PRF: %v", prf)
+	}
+	return pbkdf2.Key(password, salt, c, dkLen, sha256.New), nil
+}
+
+// argon2idKDF derives keys with Argon2id, giving callers a memory-hard
+// alternative to scrypt with independently tunable time/memory/threads
+// costs.
+type argon2idKDF struct{}
+
+func (argon2idKDF) Name() string { return "argon2id" }
+
+func (argon2idKDF) DefaultParams() map[string]interface{} {
+	return map[string]interface{}{
+		"time":    1,
+		"memory":  64 * 1024,
+		"threads": 4,
+	}
+}
+
+func (argon2idKDF) Derive(password, salt []byte, params map[string]interface{}, dkLen int) ([]byte, error) {
+	time, ok := safeEnsureInt(params["time"])
+	if !ok {
+		return nil, errors.New(`argon2id: missing or invalid "time" param`)
+	}
+	memory, ok := safeEnsureInt(params["memory"])
+	if !ok {
+		return nil, errors.New(`argon2id: missing or invalid "memory" param`)
+	}
+	threads, ok := safeEnsureInt(params["threads"])
+	if !ok {
+		return nil, errors.New(`argon2id: missing or invalid "threads" param`)
+	}
+	return argon2.IDKey(password, salt, uint32(time), uint32(memory), uint8(threads), uint32(dkLen)), nil
+}
+
+// safeEnsureInt recovers an int from KDF/keystore params that may have
+// round-tripped through JSON (where integers decode as float64), without
+// panicking on a missing or wrong-typed field the way a bare type
+// assertion would. All of this package's untrusted JSON — keystore files,
+// stream headers — flows through here rather than a bare assertion.
+func safeEnsureInt(x interface{}) (int, bool) {
+	switch v := x.(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,101 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestKDFRegistryDerive exercises every registered KDF (scrypt, pbkdf2,
+// argon2id) through the common Derive contract: deterministic for
+// identical inputs, the requested output length, and salt-dependent.
+func TestKDFRegistryDerive(t *testing.T) {
+	password := []byte("hunter2")
+	saltA := []byte("AAAAAAAAAAAAAAAA")
+	saltB := []byte("BBBBBBBBBBBBBBBB")
+	const dkLen = 32
+
+	for name, kdf := range kdfRegistry {
+		params := kdf.DefaultParams()
+		if name == "scrypt" {
+			// the default scrypt cost (stdScryptN) targets ~1s/256MB;
+			// use the light profile so the test suite stays fast.
+			params["n"], params["r"], params["p"] = lightScryptN, scryptR, lightScryptP
+		}
+
+		got1, err := kdf.Derive(password, saltA, params, dkLen)
+		if err != nil {
+			t.Fatalf("%s: Derive: %v", name, err)
+		}
+		if len(got1) != dkLen {
+			t.Errorf("%s: Derive returned %d bytes, want %d", name, len(got1), dkLen)
+		}
+
+		got2, err := kdf.Derive(password, saltA, params, dkLen)
+		if err != nil {
+			t.Fatalf("%s: Derive (again): %v", name, err)
+		}
+		if !bytes.Equal(got1, got2) {
+			t.Errorf("%s: Derive is not deterministic for identical inputs", name)
+		}
+
+		gotB, err := kdf.Derive(password, saltB, params, dkLen)
+		if err != nil {
+			t.Fatalf("%s: Derive (other salt): %v", name, err)
+		}
+		if bytes.Equal(got1, gotB) {
+			t.Errorf("%s: Derive produced identical output for two different salts", name)
+		}
+	}
+}
+
+// TestScryptKDFRFC7914Vector checks scryptKDF.Derive against the first
+// test vector from RFC 7914 section 12, rather than only against its own
+// round-tripped output: a scrypt wrapper that passes n/r/p to the wrong
+// arguments (but does so consistently) would pass a self-consistency
+// check yet still diverge from every other scrypt implementation.
+func TestScryptKDFRFC7914Vector(t *testing.T) {
+	const want = "77d6576238657b203b19ca42c18a0497" +
+		"f16b4844e3074ae8dfdffa3fede21442" +
+		"fcd0069ded0948f8326a753a0fc81f17" +
+		"e8d3e0fb2e0d3628cf35e20c38d18906"
+
+	params := map[string]interface{}{"n": 16, "r": 1, "p": 1}
+	got, err := kdfRegistry["scrypt"].Derive(nil, nil, params, 64)
+	if err != nil {
+		t.Fatalf("Derive: %v", err)
+	}
+	if hex.EncodeToString(got) != want {
+		t.Fatalf("scrypt(\"\", \"\", N=16, r=1, p=1) = %x, want %s", got, want)
+	}
+}
+
+// TestPBKDF2KDFKnownVector checks pbkdf2KDF.Derive against the widely
+// reproduced PBKDF2-HMAC-SHA256("password", "salt", c=1, dkLen=32) test
+// vector, for the same reason TestScryptKDFRFC7914Vector exists: it
+// catches a Derive that's internally consistent but wrong against the
+// real algorithm.
+func TestPBKDF2KDFKnownVector(t *testing.T) {
+	const want = "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"
+
+	params := map[string]interface{}{"c": 1, "prf": "hmac-sha256"}
+	got, err := kdfRegistry["pbkdf2"].Derive([]byte("password"), []byte("salt"), params, 32)
+	if err != nil {
+		t.Fatalf("Derive: %v", err)
+	}
+	if hex.EncodeToString(got) != want {
+		t.Fatalf("pbkdf2(\"password\", \"salt\", c=1) = %x, want %s", got, want)
+	}
+}
+
+// TestResolveKDFOverridesParams checks that caller-supplied kdfParams win
+// over the safety level's defaults, as NewKeyStorePassphrase promises.
+func TestResolveKDFOverridesParams(t *testing.T) {
+	kdf, params := resolveKDF(KDFArgon2, map[string]interface{}{"memory": 8 * 1024})
+	if kdf.Name() != "argon2id" {
+		t.Fatalf("KDFArgon2 resolved to %q, want argon2id", kdf.Name())
+	}
+	if params["memory"] != 8*1024 {
+		t.Errorf("caller-supplied memory override was not applied: got %v", params["memory"])
+	}
+}
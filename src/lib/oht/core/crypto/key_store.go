@@ -0,0 +1,35 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"io"
+
+	"lib/oht/core/common"
+)
+
+// KeyStore is the interface every key-management backend in this package
+// implements. Callers should depend on KeyStore rather than a concrete
+// type so file-backed and bolt-backed keystores are interchangeable.
+type KeyStore interface {
+	GenerateNewKey(rand io.Reader, auth string) (*Key, error)
+	GetKey(addr common.Address, auth string) (*Key, error)
+	GetKeyAddresses() ([]common.Address, error)
+	StoreKey(key *Key, auth string) error
+	DeleteKey(addr common.Address, auth string) error
+	Cleanup(addr common.Address) error
+
+	// MigrateKey decrypts whichever keystore version is currently stored
+	// for addr and, if it's the legacy v1 format, re-encrypts it as v3.
+	MigrateKey(addr common.Address, auth string) error
+
+	// ImportECDSA stores an existing ECDSA private key, e.g. one produced
+	// by ImportPreSaleKey, under auth.
+	ImportECDSA(priv *ecdsa.PrivateKey, auth string) (*Key, error)
+	// ExportKey decrypts addr under auth and re-encrypts it as v3
+	// keystore JSON, so it can be imported by any Web3 Secret Storage v3
+	// implementation.
+	ExportKey(addr common.Address, auth string) ([]byte, error)
+	// ImportPreSaleKey decrypts a legacy Ethereum presale wallet under
+	// password and stores the resulting key.
+	ImportPreSaleKey(fileContent []byte, password string) (*Key, error)
+}
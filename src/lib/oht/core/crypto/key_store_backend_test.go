@@ -0,0 +1,99 @@
+package crypto
+
+import (
+	"testing"
+
+	"lib/oht/core/common"
+)
+
+// fakeKeyBackend is an in-memory keyBackend test double, so the
+// keyStorePassphrase methods can be exercised without touching the
+// filesystem or a real database.Store.
+type fakeKeyBackend map[common.Address][]byte
+
+func (b fakeKeyBackend) Put(addr common.Address, keyJSON []byte) error {
+	b[addr] = keyJSON
+	return nil
+}
+
+func (b fakeKeyBackend) Get(addr common.Address) ([]byte, error) {
+	return b[addr], nil
+}
+
+func (b fakeKeyBackend) Delete(addr common.Address) error {
+	delete(b, addr)
+	return nil
+}
+
+func (b fakeKeyBackend) Addresses() ([]common.Address, error) {
+	addrs := make([]common.Address, 0, len(b))
+	for addr := range b {
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+func (b fakeKeyBackend) Cleanup(addr common.Address) error {
+	return nil
+}
+
+func newTestKeyStore() *keyStorePassphrase {
+	kdf, params := resolveKDF(KDFLight)
+	return &keyStorePassphrase{fakeKeyBackend{}, kdf, params}
+}
+
+func TestImportECDSAAndExportKey(t *testing.T) {
+	ks := newTestKeyStore()
+	const auth = "s3cr3t"
+
+	priv := ToECDSA(bytes32(0x09))
+	imported, err := ks.ImportECDSA(priv, auth)
+	if err != nil {
+		t.Fatalf("ImportECDSA: %v", err)
+	}
+
+	exported, err := ks.ExportKey(imported.Address, auth)
+	if err != nil {
+		t.Fatalf("ExportKey: %v", err)
+	}
+
+	reimported := newTestKeyStore()
+	if err := reimported.backend.Put(imported.Address, exported); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	got, err := reimported.GetKey(imported.Address, auth)
+	if err != nil {
+		t.Fatalf("GetKey on exported JSON: %v", err)
+	}
+	if got.Address != imported.Address {
+		t.Fatalf("round-tripped address = %x, want %x", got.Address, imported.Address)
+	}
+}
+
+func TestMigrateKeyIsNoopForV3(t *testing.T) {
+	ks := newTestKeyStore()
+	const auth = "s3cr3t"
+
+	key, err := ks.ImportECDSA(ToECDSA(bytes32(0x07)), auth)
+	if err != nil {
+		t.Fatalf("ImportECDSA: %v", err)
+	}
+
+	if err := ks.MigrateKey(key.Address, auth); err != nil {
+		t.Fatalf("MigrateKey on an already-v3 key: %v", err)
+	}
+	if err := ks.MigrateKey(key.Address, "wrong passphrase"); err == nil {
+		t.Fatal("MigrateKey accepted the wrong passphrase for an already-v3 key")
+	}
+}
+
+// bytes32 returns a 32-byte slice filled with b, standing in for an
+// ECDSA private scalar in tests that don't care about its specific value.
+func bytes32(b byte) []byte {
+	buf := make([]byte, 32)
+	for i := range buf {
+		buf[i] = b
+	}
+	return buf
+}
@@ -0,0 +1,48 @@
+package crypto
+
+import (
+	"lib/oht/core/common"
+	"lib/oht/core/database"
+)
+
+// dbKeyBackend is a keyBackend that persists encrypted key JSON into a
+// database.Store's keys bucket instead of one file per key, so
+// StoreKey/DeleteKey/GetKeyAddresses all go through bolt's transactions
+// instead of racing against each other on the filesystem.
+type dbKeyBackend struct {
+	store *database.Store
+}
+
+func (b dbKeyBackend) Put(addr common.Address, keyJSON []byte) error {
+	return b.store.PutKey(addr, keyJSON)
+}
+
+func (b dbKeyBackend) Get(addr common.Address) ([]byte, error) {
+	return b.store.GetKey(addr)
+}
+
+func (b dbKeyBackend) Delete(addr common.Address) error {
+	return b.store.DeleteKey(addr)
+}
+
+func (b dbKeyBackend) Addresses() ([]common.Address, error) {
+	return b.store.KeyAddresses()
+}
+
+// Cleanup has nothing to do for the DB backend: there are no stray
+// temp/lock files on disk the way there are for the per-file keystore.
+func (b dbKeyBackend) Cleanup(addr common.Address) error {
+	return nil
+}
+
+// NewKeyStorePassphraseDB returns a KeyStore that persists encrypted key
+// JSON into store's keys bucket instead of individual files on disk.
+// safety and kdfParams behave exactly as they do for
+// NewKeyStorePassphrase, and the result supports every capability
+// (MigrateKey, ImportECDSA, ExportKey, ImportPreSaleKey, ...) that the
+// file-backed keystore does, since both share the same keyStorePassphrase
+// implementation behind their respective keyBackend.
+func NewKeyStorePassphraseDB(store *database.Store, safety int, kdfParams ...map[string]interface{}) KeyStore {
+	kdf, params := resolveKDF(safety, kdfParams...)
+	return &keyStorePassphrase{dbKeyBackend{store}, kdf, params}
+}
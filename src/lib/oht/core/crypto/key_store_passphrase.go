@@ -3,25 +3,29 @@ package crypto
 import (
 	"bytes"
 	"crypto/aes"
-	"crypto/sha256"
+	"crypto/cipher"
+	"crypto/ecdsa"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"runtime"
 
 	"lib/oht/core/common"
 	"lib/oht/core/crypto/randentropy"
 
 	"github.com/pborman/uuid"
-	"golang.org/x/crypto/pbkdf2"
-	"golang.org/x/crypto/scrypt"
 )
 
+// version1 identifies the legacy (pre-Web3 Secret Storage v3) keystore
+// format: AES-128-CBC with a double-SHA3 MAC, as produced by early wallets.
+const version1 = "1"
+
 const (
-	keyHeaderKDF = "scrypt"
-	KDFStandard  = iota
-	KDFLight     = iota
+	KDFStandard = iota
+	KDFLight    = iota
+	KDFArgon2   = iota
 
 	// n,r,p = 2^18, 8, 1 uses 256MB memory and approx 1s CPU time on a modern CPU.
 	stdScryptN = 1 << 18
@@ -35,20 +39,34 @@ const (
 	scryptDKLen = 32
 )
 
+// keyBackend abstracts where a keyStorePassphrase actually persists
+// encrypted key JSON, so the encrypt/decrypt/migrate logic in this file is
+// shared by every storage backend instead of duplicated per backend.
+type keyBackend interface {
+	Put(addr common.Address, keyJSON []byte) error
+	// Get returns the raw encrypted key JSON for addr, or nil if no key
+	// is stored under that address.
+	Get(addr common.Address) ([]byte, error)
+	Delete(addr common.Address) error
+	Addresses() ([]common.Address, error)
+	Cleanup(addr common.Address) error
+}
+
 type keyStorePassphrase struct {
-	keysDirPath string
-	scryptN     int
-	scryptP     int
-	scryptR     int
-	scryptDKLen int
+	backend   keyBackend
+	kdf       KDF
+	kdfParams map[string]interface{}
 }
 
-func NewKeyStorePassphrase(path string, safety int) KeyStore {
-	if safety == KDFStandard {
-		return &keyStorePassphrase{path, stdScryptN, stdScryptP, scryptR, scryptDKLen}
-	} else {
-		return &keyStorePassphrase{path, lightScryptN, lightScryptP, scryptR, scryptDKLen}
-	}
+// NewKeyStorePassphrase returns a KeyStore that encrypts keys and stores
+// them as one file per key under keysDirPath, using the KDF selected by
+// safety (KDFStandard, KDFLight or KDFArgon2). kdfParams optionally
+// overrides individual entries of that KDF's default params (e.g.
+// {"memory": 1 << 20} to raise Argon2id's memory cost), letting callers
+// tune cost without switching KDFs.
+func NewKeyStorePassphrase(path string, safety int, kdfParams ...map[string]interface{}) KeyStore {
+	kdf, params := resolveKDF(safety, kdfParams...)
+	return &keyStorePassphrase{fileKeyBackend{path}, kdf, params}
 }
 
 func (ks keyStorePassphrase) GenerateNewKey(rand io.Reader, auth string) (key *Key, err error) {
@@ -56,52 +74,115 @@ func (ks keyStorePassphrase) GenerateNewKey(rand io.Reader, auth string) (key *K
 }
 
 func (ks keyStorePassphrase) GetKey(keyAddr common.Address, auth string) (key *Key, err error) {
-	keyBytes, keyId, err := decryptKeyFromFile(ks.keysDirPath, keyAddr, auth)
-	if err == nil {
-		key = &Key{
-			Id:         uuid.UUID(keyId),
-			Address:    keyAddr,
-			PrivateKey: ToECDSA(keyBytes),
-		}
+	keyBytes, keyId, err := decryptKeyFromBackend(ks.backend, keyAddr, auth)
+	if err != nil {
+		return nil, err
+	}
+	defer memclr(keyBytes)
+
+	key = &Key{
+		Id:         uuid.UUID(keyId),
+		Address:    keyAddr,
+		PrivateKey: ToECDSA(keyBytes),
+	}
+	runtime.SetFinalizer(key, func(k *Key) { ZeroKey(k) })
+	return key, nil
+}
+
+// ZeroKey overwrites the private scalar of k's ECDSA key in place so it
+// doesn't linger in memory after the Key is no longer needed.
+func ZeroKey(k *Key) {
+	if k == nil || k.PrivateKey == nil || k.PrivateKey.D == nil {
+		return
+	}
+	words := k.PrivateKey.D.Bits()
+	for i := range words {
+		words[i] = 0
 	}
-	return
 }
 
 func (ks keyStorePassphrase) Cleanup(keyAddr common.Address) (err error) {
-	return cleanup(ks.keysDirPath, keyAddr)
+	return ks.backend.Cleanup(keyAddr)
 }
 
 func (ks keyStorePassphrase) GetKeyAddresses() (addresses []common.Address, err error) {
-	return getKeyAddresses(ks.keysDirPath)
+	return ks.backend.Addresses()
 }
 
 func (ks keyStorePassphrase) StoreKey(key *Key, auth string) (err error) {
+	keyJSON, err := encryptKeyJSON(key, auth, ks.kdf, ks.kdfParams)
+	if err != nil {
+		return err
+	}
+	return ks.backend.Put(key.Address, keyJSON)
+}
+
+// fileKeyBackend is the original one-file-per-key keyBackend: each key
+// lives at its own path under keysDirPath, named and laid out by
+// writeKeyFile/getKey/deleteKey/getKeyAddresses/cleanup.
+type fileKeyBackend struct {
+	keysDirPath string
+}
+
+func (b fileKeyBackend) Put(addr common.Address, keyJSON []byte) error {
+	return writeKeyFile(addr, b.keysDirPath, keyJSON)
+}
+
+func (b fileKeyBackend) Get(addr common.Address) ([]byte, error) {
+	m := make(map[string]interface{})
+	if err := getKey(b.keysDirPath, addr, &m); err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+func (b fileKeyBackend) Delete(addr common.Address) error {
+	return deleteKey(b.keysDirPath, addr)
+}
+
+func (b fileKeyBackend) Addresses() ([]common.Address, error) {
+	return getKeyAddresses(b.keysDirPath)
+}
+
+func (b fileKeyBackend) Cleanup(addr common.Address) error {
+	return cleanup(b.keysDirPath, addr)
+}
+
+// encryptKeyJSON derives a key from auth via kdf, uses it to encrypt key's
+// private scalar, and returns the resulting v3 keystore JSON. It is shared
+// by every KeyStore backend (file-per-key, bolt-backed) so they all produce
+// the same JSON layout; salt and IV are fresh random values on every call,
+// so two encryptions of the same key/auth/kdf differ byte for byte.
+func encryptKeyJSON(key *Key, auth string, kdf KDF, kdfParams map[string]interface{}) ([]byte, error) {
 	authArray := []byte(auth)
 	salt := randentropy.GetEntropyCSPRNG(32)
+
+	kdfParamsJSON := make(map[string]interface{}, len(kdfParams)+2)
+	for k, v := range kdfParams {
+		kdfParamsJSON[k] = v
+	}
+	kdfParamsJSON["dklen"] = scryptDKLen
+	kdfParamsJSON["salt"] = hex.EncodeToString(salt)
+
 	//now := time.Now()
-	derivedKey, err := scrypt.Key(authArray, salt, ks.scryptN, ks.scryptR, ks.scryptP, ks.scryptDKLen)
+	derivedKey, err := kdf.Derive(authArray, salt, kdfParamsJSON, scryptDKLen)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer memclr(derivedKey)
 	//fmt.Println("took: ", time.Since(now))
 	encryptKey := derivedKey[:16]
 	keyBytes := FromECDSA(key.PrivateKey)
+	defer memclr(keyBytes)
 
 	iv := randentropy.GetEntropyCSPRNG(aes.BlockSize) // 16
 	cipherText, err := aesCTRXOR(encryptKey, keyBytes, iv)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	mac := Sha3(derivedKey[16:32], cipherText)
 
-	scryptParamsJSON := make(map[string]interface{}, 5)
-	scryptParamsJSON["n"] = ks.scryptN
-	scryptParamsJSON["r"] = ks.scryptR
-	scryptParamsJSON["p"] = ks.scryptP
-	scryptParamsJSON["dklen"] = ks.scryptDKLen
-	scryptParamsJSON["salt"] = hex.EncodeToString(salt)
-
 	cipherParamsJSON := cipherparamsJSON{
 		IV: hex.EncodeToString(iv),
 	}
@@ -110,8 +191,8 @@ func (ks keyStorePassphrase) StoreKey(key *Key, auth string) (err error) {
 		Cipher:       "aes-128-ctr",
 		CipherText:   hex.EncodeToString(cipherText),
 		CipherParams: cipherParamsJSON,
-		KDF:          "scrypt",
-		KDFParams:    scryptParamsJSON,
+		KDF:          kdf.Name(),
+		KDFParams:    kdfParamsJSON,
 		MAC:          hex.EncodeToString(mac),
 	}
 	encryptedKeyJSON := encryptedKeyJSON{
@@ -120,36 +201,126 @@ func (ks keyStorePassphrase) StoreKey(key *Key, auth string) (err error) {
 		key.Id.String(),
 		version,
 	}
-	keyJSON, err := json.Marshal(encryptedKeyJSON)
+	return json.Marshal(encryptedKeyJSON)
+}
+
+func (ks keyStorePassphrase) DeleteKey(keyAddr common.Address, auth string) (err error) {
+	// only delete if correct passphrase is given
+	_, _, err = decryptKeyFromBackend(ks.backend, keyAddr, auth)
 	if err != nil {
 		return err
 	}
 
-	return writeKeyFile(key.Address, ks.keysDirPath, keyJSON)
+	return ks.backend.Delete(keyAddr)
 }
 
-func (ks keyStorePassphrase) DeleteKey(keyAddr common.Address, auth string) (err error) {
-	// only delete if correct passphrase is given
-	_, _, err = decryptKeyFromFile(ks.keysDirPath, keyAddr, auth)
+// ImportECDSA wraps an existing ECDSA private key as a Key and stores it
+// under auth, so keys generated elsewhere (e.g. ImportPreSaleKey) can be
+// added to this keystore.
+func (ks keyStorePassphrase) ImportECDSA(priv *ecdsa.PrivateKey, auth string) (key *Key, err error) {
+	key = &Key{
+		Id:         uuid.NewRandom(),
+		Address:    PubkeyToAddress(priv.PublicKey),
+		PrivateKey: priv,
+	}
+	if err = ks.StoreKey(key, auth); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ExportKey decrypts keyAddr with auth and re-encrypts it as v3 keystore
+// JSON, so the result can be imported by any Web3 Secret Storage v3
+// implementation regardless of which version this keystore actually holds
+// the key in on disk.
+func (ks keyStorePassphrase) ExportKey(keyAddr common.Address, auth string) ([]byte, error) {
+	key, err := ks.GetKey(keyAddr, auth)
 	if err != nil {
+		return nil, err
+	}
+	defer ZeroKey(key)
+	// v3 readers only know scrypt and pbkdf2, so an argon2id keystore
+	// exports under the standard scrypt params instead of its own KDF.
+	kdf, kdfParams := ks.kdf, ks.kdfParams
+	if _, ok := kdf.(argon2idKDF); ok {
+		kdf, kdfParams = resolveKDF(KDFStandard)
+	}
+	return encryptKeyJSON(key, auth, kdf, kdfParams)
+}
+
+// MigrateKey decrypts whichever keystore version is currently on disk for
+// keyAddr and, if it was stored in the legacy v1 format, re-encrypts it as
+// v3 and atomically replaces the file. It is a no-op (beyond validating the
+// passphrase) for keys that are already v3.
+func (ks keyStorePassphrase) MigrateKey(keyAddr common.Address, auth string) (err error) {
+	data, err := ks.backend.Get(keyAddr)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return errors.New("no key for given address")
+	}
+
+	m := make(map[string]interface{})
+	if err = json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	if fmt.Sprintf("%v", m["version"]) != version1 {
+		// already current, just confirm the passphrase is correct
+		_, _, err = decryptKeyJSON(data, auth)
 		return err
 	}
 
-	return deleteKey(ks.keysDirPath, keyAddr)
+	keyBytes, keyId, err := decryptKeyJSON(data, auth)
+	if err != nil {
+		return err
+	}
+	defer memclr(keyBytes)
+
+	key := &Key{
+		Id:         uuid.UUID(keyId),
+		Address:    keyAddr,
+		PrivateKey: ToECDSA(keyBytes),
+	}
+	return ks.StoreKey(key, auth)
 }
 
-func decryptKeyFromFile(keysDirPath string, keyAddr common.Address, auth string) (keyBytes []byte, keyId []byte, err error) {
-	m := make(map[string]interface{})
-	err = getKey(keysDirPath, keyAddr, &m)
+// decryptKeyFromBackend fetches the raw encrypted key JSON for keyAddr
+// from backend and decrypts it under auth. It is shared by every
+// keyStorePassphrase backend so file-per-key and bolt-backed stores agree
+// on what "no key" and "wrong passphrase" look like.
+func decryptKeyFromBackend(backend keyBackend, keyAddr common.Address, auth string) (keyBytes []byte, keyId []byte, err error) {
+	data, err := backend.Get(keyAddr)
 	if err != nil {
-		return
+		return nil, nil, err
+	}
+	if data == nil {
+		return nil, nil, errors.New("no key for given address")
+	}
+	return decryptKeyJSON(data, auth)
+}
+
+// decryptKeyJSON decrypts a marshaled v1 or v3 keystore blob, selecting the
+// format by inspecting its "version" field. Used by every KeyStore backend
+// so a single implementation of the version dispatch (and the v1 migration
+// path) covers them all.
+func decryptKeyJSON(data []byte, auth string) (keyBytes []byte, keyId []byte, err error) {
+	m := make(map[string]interface{})
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, nil, err
+	}
+
+	if fmt.Sprintf("%v", m["version"]) == version1 {
+		k := new(encryptedKeyJSONV1)
+		if err = json.Unmarshal(data, k); err != nil {
+			return nil, nil, err
+		}
+		return decryptKeyV1(k, auth)
 	}
 
-	//v := reflect.ValueOf(m["version"])
 	k := new(encryptedKeyJSON)
-	err = getKey(keysDirPath, keyAddr, &k)
-	if err != nil {
-		return
+	if err = json.Unmarshal(data, k); err != nil {
+		return nil, nil, err
 	}
 	return decryptKey(k, auth)
 }
@@ -183,6 +354,7 @@ func decryptKey(keyProtected *encryptedKeyJSON, auth string) (keyBytes []byte, k
 	if err != nil {
 		return nil, nil, err
 	}
+	defer memclr(derivedKey)
 
 	calculatedMAC := Sha3(derivedKey[16:32], cipherText)
 	if !bytes.Equal(calculatedMAC, mac) {
@@ -197,39 +369,141 @@ func decryptKey(keyProtected *encryptedKeyJSON, auth string) (keyBytes []byte, k
 }
 
 func getKDFKey(cryptoJSON cryptoJSON, auth string) ([]byte, error) {
-	authArray := []byte(auth)
-	salt, err := hex.DecodeString(cryptoJSON.KDFParams["salt"].(string))
+	kdf, ok := kdfRegistry[cryptoJSON.KDF]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported KDF: %v", cryptoJSON.KDF)
+	}
+
+	saltHex, ok := cryptoJSON.KDFParams["salt"].(string)
+	if !ok {
+		return nil, errors.New(`keystore: missing or invalid "salt" param`)
+	}
+	salt, err := hex.DecodeString(saltHex)
 	if err != nil {
 		return nil, err
 	}
-	dkLen := ensureInt(cryptoJSON.KDFParams["dklen"])
+	dkLen, ok := safeEnsureInt(cryptoJSON.KDFParams["dklen"])
+	if !ok {
+		return nil, errors.New(`keystore: missing or invalid "dklen" param`)
+	}
 
-	if cryptoJSON.KDF == "scrypt" {
-		n := ensureInt(cryptoJSON.KDFParams["n"])
-		r := ensureInt(cryptoJSON.KDFParams["r"])
-		p := ensureInt(cryptoJSON.KDFParams["p"])
-		return scrypt.Key(authArray, salt, n, r, p, dkLen)
+	return kdf.Derive([]byte(auth), salt, cryptoJSON.KDFParams, dkLen)
+}
 
-	} else if cryptoJSON.KDF == "pbkdf2" {
-		c := ensureInt(cryptoJSON.KDFParams["c"])
-		prf := cryptoJSON.KDFParams["prf"].(string)
-		if prf != "hmac-sha256" {
-			return nil, fmt.Errorf("Unsupported PBKDF2 PRF: ", prf)
-		}
-		key := pbkdf2.Key(authArray, salt, c, dkLen, sha256.New)
-		return key, nil
+// encryptedKeyJSONV1 mirrors the legacy keystore layout: the KDF lives
+// under a nested KeyHeader object inside Crypto rather than in Crypto's own
+// kdf/kdfparams fields, and the cipher is AES-128-CBC instead of CTR.
+type encryptedKeyJSONV1 struct {
+	Address string       `json:"address"`
+	Crypto  cryptoJSONV1 `json:"Crypto"`
+	Id      string       `json:"id"`
+	Version string       `json:"version"`
+}
+
+type cryptoJSONV1 struct {
+	Cipher       string           `json:"cipher"`
+	CipherText   string           `json:"ciphertext"`
+	CipherParams cipherparamsJSON `json:"cipherparams"`
+	KeyHeader    keyHeaderJSONV1  `json:"KeyHeader"`
+	MAC          string           `json:"mac"`
+}
+
+type keyHeaderJSONV1 struct {
+	Kdf       string                 `json:"kdf"`
+	KdfParams map[string]interface{} `json:"kdfparams"`
+}
+
+func decryptKeyV1(keyProtected *encryptedKeyJSONV1, auth string) (keyBytes []byte, keyId []byte, err error) {
+	if keyProtected.Version != version1 {
+		return nil, nil, fmt.Errorf("Version not supported: %v", keyProtected.Version)
+	}
+
+	if keyProtected.Crypto.Cipher != "aes-128-cbc" {
+		return nil, nil, fmt.Errorf("Cipher not supported: %v", keyProtected.Crypto.Cipher)
+	}
+
+	keyId = uuid.Parse(keyProtected.Id)
+	mac, err := hex.DecodeString(keyProtected.Crypto.MAC)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	iv, err := hex.DecodeString(keyProtected.Crypto.CipherParams.IV)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cipherText, err := hex.DecodeString(keyProtected.Crypto.CipherText)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	derivedKey, err := getKDFKeyV1(keyProtected.Crypto.KeyHeader, auth)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer memclr(derivedKey)
+
+	// v1 MACs the ciphertext against sha3(derivedKey[16:32]) rather than
+	// the raw slice used by v3.
+	calculatedMAC := Sha3(Sha3(derivedKey[16:32]), cipherText)
+	if !bytes.Equal(calculatedMAC, mac) {
+		return nil, nil, errors.New("Decryption failed: MAC mismatch")
 	}
 
-	return nil, fmt.Errorf("Unsupported KDF: ", cryptoJSON.KDF)
+	plainText, err := aesCBCDecrypt(derivedKey[:16], cipherText, iv)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plainText, keyId, err
 }
 
-// TODO: can we do without this when unmarshalling dynamic JSON?
-// why do integers in KDF params end up as float64 and not int after
-// unmarshal?
-func ensureInt(x interface{}) int {
-	res, ok := x.(int)
+func getKDFKeyV1(header keyHeaderJSONV1, auth string) ([]byte, error) {
+	kdf, ok := kdfRegistry[header.Kdf]
 	if !ok {
-		res = int(x.(float64))
+		return nil, fmt.Errorf("Unsupported KDF: %v", header.Kdf)
+	}
+
+	saltHex, ok := header.KdfParams["salt"].(string)
+	if !ok {
+		return nil, errors.New(`keystore: missing or invalid "salt" param`)
+	}
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return nil, err
+	}
+	dkLen, ok := safeEnsureInt(header.KdfParams["dklen"])
+	if !ok {
+		return nil, errors.New(`keystore: missing or invalid "dklen" param`)
+	}
+
+	return kdf.Derive([]byte(auth), salt, header.KdfParams, dkLen)
+}
+
+// aesCBCDecrypt decrypts ciphertext produced by the legacy v1 keystore,
+// which pads its plaintext with PKCS#7 before encrypting with AES-128-CBC.
+func aesCBCDecrypt(key, cipherText, iv []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(cipherText) == 0 || len(cipherText)%aes.BlockSize != 0 {
+		return nil, errors.New("cipherText is not a multiple of the block size")
+	}
+
+	decrypted := make([]byte, len(cipherText))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, cipherText)
+	return pkcs7Unpad(decrypted)
+}
+
+func pkcs7Unpad(data []byte) ([]byte, error) {
+	length := len(data)
+	if length == 0 {
+		return nil, errors.New("pkcs7: data is empty")
+	}
+	padLen := int(data[length-1])
+	if padLen == 0 || padLen > aes.BlockSize {
+		return nil, errors.New("pkcs7: invalid padding")
 	}
-	return res
+	return data[:length-padLen], nil
 }
@@ -0,0 +1,85 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"lib/oht/core/common"
+
+	"github.com/pborman/uuid"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	preSaleKdfIterations = 2000
+	preSaleKdfKeyLen     = 16
+)
+
+// preSaleKeyJSON mirrors the JSON layout of a legacy Ethereum presale
+// wallet file: an AES-128-CBC-encrypted seed plus the address it's
+// expected to decrypt to.
+type preSaleKeyJSON struct {
+	EncSeed string `json:"encseed"`
+	EthAddr string `json:"ethaddr"`
+	Email   string `json:"email"`
+}
+
+// ImportPreSaleKey decrypts a legacy Ethereum presale wallet (the raw
+// {"encseed","ethaddr","email"} JSON in fileContent) under password and
+// stores the resulting key in ks, so presale wallets can be carried
+// forward into this keystore.
+func (ks keyStorePassphrase) ImportPreSaleKey(fileContent []byte, password string) (*Key, error) {
+	key, err := decryptPreSaleKey(fileContent, password)
+	if err != nil {
+		return nil, err
+	}
+	if err = ks.StoreKey(key, password); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// decryptPreSaleKey derives the seed-encryption key as
+// pbkdf2(password, password, 2000, 16, sha256), decrypts the presale
+// wallet's encseed with it, and derives the ECDSA private key as
+// sha3(seed), verifying the result matches the wallet's ethaddr.
+func decryptPreSaleKey(fileContent []byte, password string) (*Key, error) {
+	var preSaleKey preSaleKeyJSON
+	if err := json.Unmarshal(fileContent, &preSaleKey); err != nil {
+		return nil, err
+	}
+
+	encSeed, err := hex.DecodeString(preSaleKey.EncSeed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encseed: %v", err)
+	}
+	if len(encSeed) < aes.BlockSize {
+		return nil, errors.New("encseed shorter than one AES block")
+	}
+	iv, cipherText := encSeed[:aes.BlockSize], encSeed[aes.BlockSize:]
+
+	derivedKey := pbkdf2.Key([]byte(password), []byte(password), preSaleKdfIterations, preSaleKdfKeyLen, sha256.New)
+	defer memclr(derivedKey)
+
+	seed, err := aesCBCDecrypt(derivedKey, cipherText, iv)
+	if err != nil {
+		return nil, fmt.Errorf("invalid password")
+	}
+
+	priv := ToECDSA(Sha3(seed))
+	address := PubkeyToAddress(priv.PublicKey)
+	if expected := common.HexToAddress(preSaleKey.EthAddr); !bytes.Equal(address[:], expected[:]) {
+		return nil, fmt.Errorf("decrypted address mismatch: have %x, want %x", address, expected)
+	}
+
+	return &Key{
+		Id:         uuid.NewRandom(),
+		Address:    address,
+		PrivateKey: priv,
+	}, nil
+}
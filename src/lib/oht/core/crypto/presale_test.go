@@ -0,0 +1,108 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// preSaleFixture builds a legacy presale wallet JSON blob (pbkdf2 +
+// AES-128-CBC) encrypting seed under password, deriving ethaddr the same
+// way decryptPreSaleKey will so the fixture is internally consistent.
+func preSaleFixture(t *testing.T, password string, seed []byte) []byte {
+	t.Helper()
+
+	priv := ToECDSA(Sha3(seed))
+	addr := PubkeyToAddress(priv.PublicKey)
+
+	derivedKey := pbkdf2.Key([]byte(password), []byte(password), preSaleKdfIterations, preSaleKdfKeyLen, sha256.New)
+	iv := bytes.Repeat([]byte{0x55}, aes.BlockSize)
+	cipherText, err := aesCBCEncrypt(derivedKey, seed, iv)
+	if err != nil {
+		t.Fatalf("aesCBCEncrypt: %v", err)
+	}
+	encSeed := append(append([]byte{}, iv...), cipherText...)
+
+	fileContent, err := json.Marshal(preSaleKeyJSON{
+		EncSeed: hex.EncodeToString(encSeed),
+		EthAddr: hex.EncodeToString(addr[:]),
+		Email:   "wallet@example.com",
+	})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	return fileContent
+}
+
+func TestDecryptPreSaleKeyKnownSeed(t *testing.T) {
+	const password = "presalepass"
+	seed := []byte("abc")
+
+	if got := hex.EncodeToString(Sha3(seed)); got != knownKeccak256ABC {
+		t.Fatalf("Sha3(%q) = %s, want %s (known Keccak-256 test vector)", seed, got, knownKeccak256ABC)
+	}
+
+	fileContent := preSaleFixture(t, password, seed)
+	key, err := decryptPreSaleKey(fileContent, password)
+	if err != nil {
+		t.Fatalf("decryptPreSaleKey: %v", err)
+	}
+
+	wantPriv, err := hex.DecodeString(knownKeccak256ABC)
+	if err != nil {
+		t.Fatalf("DecodeString: %v", err)
+	}
+	wantAddr := PubkeyToAddress(ToECDSA(wantPriv).PublicKey)
+	if key.Address != wantAddr {
+		t.Fatalf("decryptPreSaleKey address = %x, want %x", key.Address, wantAddr)
+	}
+}
+
+func TestDecryptPreSaleKey(t *testing.T) {
+	const password = "presalepass"
+	seed := bytes.Repeat([]byte{0x11}, 32)
+	fileContent := preSaleFixture(t, password, seed)
+
+	key, err := decryptPreSaleKey(fileContent, password)
+	if err != nil {
+		t.Fatalf("decryptPreSaleKey: %v", err)
+	}
+
+	wantAddr := PubkeyToAddress(ToECDSA(Sha3(seed)).PublicKey)
+	if key.Address != wantAddr {
+		t.Fatalf("decryptPreSaleKey address = %x, want %x", key.Address, wantAddr)
+	}
+}
+
+func TestDecryptPreSaleKeyWrongPassword(t *testing.T) {
+	seed := bytes.Repeat([]byte{0x22}, 32)
+	fileContent := preSaleFixture(t, "right", seed)
+
+	if _, err := decryptPreSaleKey(fileContent, "wrong"); err == nil {
+		t.Fatal("decryptPreSaleKey succeeded with the wrong password")
+	}
+}
+
+func TestDecryptPreSaleKeyAddressMismatch(t *testing.T) {
+	seed := bytes.Repeat([]byte{0x33}, 32)
+	fileContent := preSaleFixture(t, "presalepass", seed)
+
+	var tampered preSaleKeyJSON
+	if err := json.Unmarshal(fileContent, &tampered); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	tampered.EthAddr = "0000000000000000000000000000000000000000"
+	fileContent, err := json.Marshal(tampered)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	if _, err := decryptPreSaleKey(fileContent, "presalepass"); err == nil {
+		t.Fatal("decryptPreSaleKey accepted a seed/ethaddr mismatch")
+	}
+}
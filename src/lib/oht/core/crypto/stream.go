@@ -0,0 +1,207 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"lib/oht/core/crypto/randentropy"
+)
+
+// streamHeaderJSON is the envelope written ahead of the ciphertext by
+// EncryptStream: the same cipher/KDF fields as the per-key keystore JSON,
+// but framed as its own line so the reader knows where the header ends and
+// the ciphertext begins.
+type streamHeaderJSON struct {
+	Cipher       string                 `json:"cipher"`
+	CipherParams cipherparamsJSON       `json:"cipherparams"`
+	KDF          string                 `json:"kdf"`
+	KDFParams    map[string]interface{} `json:"kdfparams"`
+}
+
+// EncryptStream derives a key from auth with scrypt at its standard
+// (KDFStandard) cost, whatever KDF a keystore is configured with, then
+// encrypts r into w as aes-128-ctr ciphertext, writing a streamHeaderJSON
+// line first and an HMAC-SHA256 tag (computed over the ciphertext) after
+// the last ciphertext byte. Unlike StoreKey, it processes r incrementally
+// so arbitrarily large blobs never need to be held in memory whole.
+func EncryptStream(w io.Writer, r io.Reader, auth string) (err error) {
+	kdf := kdfRegistry["scrypt"]
+	salt := randentropy.GetEntropyCSPRNG(32)
+	kdfParams := kdf.DefaultParams()
+	kdfParams["salt"] = hex.EncodeToString(salt)
+	kdfParams["dklen"] = scryptDKLen
+
+	derivedKey, err := kdf.Derive([]byte(auth), salt, kdfParams, scryptDKLen)
+	if err != nil {
+		return err
+	}
+	defer memclr(derivedKey)
+
+	iv := randentropy.GetEntropyCSPRNG(aes.BlockSize)
+	block, err := aes.NewCipher(derivedKey[:16])
+	if err != nil {
+		return err
+	}
+
+	header := streamHeaderJSON{
+		Cipher:       "aes-128-ctr",
+		CipherParams: cipherparamsJSON{IV: hex.EncodeToString(iv)},
+		KDF:          kdf.Name(),
+		KDFParams:    kdfParams,
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(headerJSON); err != nil {
+		return err
+	}
+	if _, err = w.Write([]byte("\n")); err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, derivedKey[16:32])
+	sw := &cipher.StreamWriter{S: cipher.NewCTR(block, iv), W: io.MultiWriter(w, mac)}
+	if _, err = io.Copy(sw, r); err != nil {
+		return err
+	}
+
+	_, err = w.Write(mac.Sum(nil))
+	return err
+}
+
+// DecryptStream reverses EncryptStream: it reads the header line, derives
+// the same key from auth, and streams the decrypted ciphertext into w while
+// computing the HMAC as it goes. The trailing tag is checked only once the
+// input is exhausted, so plaintext has already reached w when a MAC
+// mismatch is reported.
+func DecryptStream(w io.Writer, r io.Reader, auth string) (err error) {
+	br := bufio.NewReader(r)
+	headerLine, err := br.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+
+	var header streamHeaderJSON
+	if err = json.Unmarshal(bytes.TrimRight(headerLine, "\n"), &header); err != nil {
+		return err
+	}
+	if header.Cipher != "aes-128-ctr" {
+		return fmt.Errorf("Cipher not supported: %v", header.Cipher)
+	}
+
+	kdf, ok := kdfRegistry[header.KDF]
+	if !ok {
+		return fmt.Errorf("Unsupported KDF: %v", header.KDF)
+	}
+
+	saltHex, ok := header.KDFParams["salt"].(string)
+	if !ok {
+		return errors.New("stream header: missing or invalid salt")
+	}
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return err
+	}
+	iv, err := hex.DecodeString(header.CipherParams.IV)
+	if err != nil {
+		return err
+	}
+	dkLen, ok := safeEnsureInt(header.KDFParams["dklen"])
+	if !ok {
+		return errors.New("stream header: missing or invalid dklen")
+	}
+
+	derivedKey, err := kdf.Derive([]byte(auth), salt, header.KDFParams, dkLen)
+	if err != nil {
+		return err
+	}
+	defer memclr(derivedKey)
+
+	block, err := aes.NewCipher(derivedKey[:16])
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, derivedKey[16:32])
+	trailer := newTrailingReader(br, mac.Size())
+	sr := &cipher.StreamReader{S: cipher.NewCTR(block, iv), R: io.TeeReader(trailer, mac)}
+	if _, err = io.Copy(w, sr); err != nil {
+		return err
+	}
+
+	tag, err := trailer.Trailer()
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac.Sum(nil), tag) {
+		return errors.New("Decryption failed: MAC mismatch")
+	}
+	return nil
+}
+
+// trailingReader reads from r but withholds the last n bytes from its
+// callers until the underlying reader is exhausted, so a fixed-size trailer
+// (here, the stream's HMAC tag) appended after unknown-length content can
+// be recovered without buffering the whole stream.
+type trailingReader struct {
+	r   io.Reader
+	n   int
+	buf []byte
+	err error
+}
+
+func newTrailingReader(r io.Reader, n int) *trailingReader {
+	return &trailingReader{r: r, n: n}
+}
+
+func (t *trailingReader) Read(p []byte) (int, error) {
+	chunk := make([]byte, len(p))
+	nr, err := t.r.Read(chunk)
+	t.buf = append(t.buf, chunk[:nr]...)
+	t.err = err
+
+	if len(t.buf) <= t.n {
+		if err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+
+	avail := len(t.buf) - t.n
+	copied := copy(p, t.buf[:avail])
+	t.buf = t.buf[copied:]
+	if err != nil && err != io.EOF {
+		return copied, err
+	}
+	return copied, nil
+}
+
+// Trailer returns the final n bytes withheld from Read. Call it only after
+// Read has returned io.EOF.
+func (t *trailingReader) Trailer() ([]byte, error) {
+	if t.err != nil && t.err != io.EOF {
+		return nil, t.err
+	}
+	if len(t.buf) != t.n {
+		return nil, errors.New("stream too short: missing MAC")
+	}
+	return t.buf, nil
+}
+
+// memclr zeroes b in place so derived key material doesn't linger on the
+// heap after use.
+func memclr(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,45 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptDecryptStreamRoundTrip(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog"), 100)
+
+	var encrypted bytes.Buffer
+	if err := EncryptStream(&encrypted, bytes.NewReader(plaintext), "s3cr3t"); err != nil {
+		t.Fatalf("EncryptStream: %v", err)
+	}
+
+	var decrypted bytes.Buffer
+	if err := DecryptStream(&decrypted, bytes.NewReader(encrypted.Bytes()), "s3cr3t"); err != nil {
+		t.Fatalf("DecryptStream: %v", err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), plaintext) {
+		t.Fatalf("round trip mismatch: got %d bytes, want %d", decrypted.Len(), len(plaintext))
+	}
+}
+
+func TestDecryptStreamWrongPassphrase(t *testing.T) {
+	var encrypted bytes.Buffer
+	if err := EncryptStream(&encrypted, bytes.NewReader([]byte("payload")), "right"); err != nil {
+		t.Fatalf("EncryptStream: %v", err)
+	}
+
+	var decrypted bytes.Buffer
+	if err := DecryptStream(&decrypted, bytes.NewReader(encrypted.Bytes()), "wrong"); err == nil {
+		t.Fatal("DecryptStream succeeded with the wrong passphrase")
+	}
+}
+
+// TestDecryptStreamMalformedHeader guards the fix for the unchecked
+// header.KDFParams["salt"]/"dklen" type assertions: a header missing
+// either field must return an error, not panic.
+func TestDecryptStreamMalformedHeader(t *testing.T) {
+	header := `{"cipher":"aes-128-ctr","cipherparams":{"iv":"00"},"kdf":"scrypt","kdfparams":{}}` + "\n"
+	if err := DecryptStream(&bytes.Buffer{}, bytes.NewReader([]byte(header)), "whatever"); err == nil {
+		t.Fatal("DecryptStream accepted a header missing salt/dklen")
+	}
+}
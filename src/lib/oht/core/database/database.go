@@ -1,19 +1,12 @@
 package database
 
 import (
-	"log"
-
-	"github.com/boltdb/bolt"
-
-	"github.com/multiverse-os/libs/oht/core/common"
+	"lib/oht/core/common"
 )
 
-func InitializeDatabase() {
-	db, err := bolt.Open(common.AbsolutePath(common.DefaultDataDir(), "oht.db"), 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	log.Println("Database initialized")
-	defer db.Close()
-
+// InitializeDatabase opens (creating if necessary) the node's bolt-backed
+// Store at its default location, migrating it to the current schema along
+// the way. The caller owns the returned Store and must Close it when done.
+func InitializeDatabase() (*Store, error) {
+	return Open(common.AbsolutePath(common.DefaultDataDir(), "oht.db"))
 }
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"encoding/binary"
+
+	"github.com/boltdb/bolt"
+)
+
+// schemaVersion is the bucket/encoding layout this build of the store
+// expects. Bump it and add an entry to migrations whenever that layout
+// changes.
+const schemaVersion uint32 = 1
+
+var metaSchemaVersionKey = []byte("schema_version")
+
+// migrations maps a target schema version to the step that produces it
+// from the previous one. There's nothing to do yet for version 1, since
+// Open already creates the initial bucket layout; later schema changes are
+// added here in order.
+var migrations = map[uint32]func(*bolt.Tx) error{}
+
+// migrate brings a freshly opened Store from whatever schema version is
+// recorded on disk up to schemaVersion, running each intermediate step and
+// persisting the new version after it succeeds.
+func (s *Store) migrate() error {
+	current, err := s.schemaVersionOnDisk()
+	if err != nil {
+		return err
+	}
+
+	for v := current; v < schemaVersion; v++ {
+		if step, ok := migrations[v+1]; ok {
+			if err := s.db.Update(step); err != nil {
+				return err
+			}
+		}
+		if err := s.setSchemaVersion(v + 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) schemaVersionOnDisk() (uint32, error) {
+	v, err := s.Get(bucketMeta, metaSchemaVersionKey)
+	if err != nil || v == nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(v), nil
+}
+
+func (s *Store) setSchemaVersion(v uint32) error {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, v)
+	return s.Put(bucketMeta, metaSchemaVersionKey, buf)
+}
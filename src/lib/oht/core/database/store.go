@@ -0,0 +1,144 @@
+package database
+
+import (
+	"io"
+
+	"github.com/boltdb/bolt"
+
+	"lib/oht/core/common"
+)
+
+var (
+	bucketKeys     = []byte("keys")
+	bucketPeers    = []byte("peers")
+	bucketMessages = []byte("messages")
+	bucketMeta     = []byte("meta")
+
+	buckets = [][]byte{bucketKeys, bucketPeers, bucketMessages, bucketMeta}
+)
+
+// Store owns a long-lived bolt.DB and the buckets the node persists state
+// into. All access goes through Update/View (or the CRUD helpers built on
+// top of them), so callers never touch bolt's transaction API directly and
+// can't leave a transaction open by mistake.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open creates (or reopens) the bolt database at path, ensures the
+// well-known buckets exist, and migrates it up to the current schema
+// version.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{db: db}
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Close releases the underlying bolt database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Update runs fn inside a read-write transaction.
+func (s *Store) Update(fn func(*bolt.Tx) error) error {
+	return s.db.Update(fn)
+}
+
+// View runs fn inside a read-only transaction.
+func (s *Store) View(fn func(*bolt.Tx) error) error {
+	return s.db.View(fn)
+}
+
+// Backup writes a consistent point-in-time copy of the whole database to w.
+func (s *Store) Backup(w io.Writer) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
+
+// Put stores value under key in bucket.
+func (s *Store) Put(bucket, key, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put(key, value)
+	})
+}
+
+// Get returns the value stored under key in bucket. Both the value and the
+// error are nil if key does not exist.
+func (s *Store) Get(bucket, key []byte) (value []byte, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucket).Get(key); v != nil {
+			value = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	return
+}
+
+// Delete removes key from bucket.
+func (s *Store) Delete(bucket, key []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete(key)
+	})
+}
+
+// ForEach walks bucket in key order via a cursor, calling fn for every
+// entry until fn returns an error or the bucket is exhausted.
+func (s *Store) ForEach(bucket []byte, fn func(key, value []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if err := fn(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PutKey persists an encrypted key JSON blob keyed by addr.
+func (s *Store) PutKey(addr common.Address, keyJSON []byte) error {
+	return s.Put(bucketKeys, addr[:], keyJSON)
+}
+
+// GetKey returns the encrypted key JSON blob stored for addr, or
+// (nil, nil) if no key is stored there.
+func (s *Store) GetKey(addr common.Address) ([]byte, error) {
+	return s.Get(bucketKeys, addr[:])
+}
+
+// DeleteKey removes the key stored for addr.
+func (s *Store) DeleteKey(addr common.Address) error {
+	return s.Delete(bucketKeys, addr[:])
+}
+
+// KeyAddresses returns every address with a key currently stored.
+func (s *Store) KeyAddresses() (addresses []common.Address, err error) {
+	err = s.ForEach(bucketKeys, func(k, _ []byte) error {
+		addresses = append(addresses, common.BytesToAddress(k))
+		return nil
+	})
+	return
+}